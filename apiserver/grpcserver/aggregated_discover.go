@@ -0,0 +1,259 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/polarismesh/polaris-server/common/api/v1"
+	"github.com/polarismesh/polaris-server/common/utils"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// aggregatedDiscoverPollInterval is how often a stream re-checks every
+// subscription it holds for a version bump. The naming cache in this tree
+// has no change-callback hook to push invalidations, so this polls instead;
+// shouldPush suppresses a resend once the client has acked the current
+// version, so a resource is sent again on each tick until the client acks it,
+// and a client that acks every push only receives actual changes. WatchConfigFiles has the
+// same gap on the config side and uses the identical poll-and-diff fallback
+// (configWatchPollInterval in config_client_access.go) rather than silently
+// differing on how the two streams cope with missing invalidation events.
+const aggregatedDiscoverPollInterval = 2 * time.Second
+
+// resourceKey identifies one (service, resource type) subscription inside an
+// AggregatedDiscover stream.
+type resourceKey struct {
+	Namespace    string
+	Service      string
+	ResourceType api.DiscoverRequest_DiscoverRequestType
+}
+
+// aggregatedDiscoverSession is the per-connection state of one
+// AggregatedDiscover stream: which (service, resource type) tuples the
+// client is subscribed to and which version of each it has last acked,
+// mirroring xDS state-of-the-world nonce/version acking so the server only
+// resends a resource once its version has actually moved past what the
+// client echoed back.
+type aggregatedDiscoverSession struct {
+	mutex         sync.Mutex
+	subscribed    map[resourceKey]bool
+	ackedVersion  map[resourceKey]string
+	pendingPushes chan resourceKey
+	done          chan error
+	closeOnce     sync.Once
+}
+
+func newAggregatedDiscoverSession() *aggregatedDiscoverSession {
+	return &aggregatedDiscoverSession{
+		subscribed:    make(map[resourceKey]bool),
+		ackedVersion:  make(map[resourceKey]string),
+		pendingPushes: make(chan resourceKey, 256),
+		done:          make(chan error, 1),
+	}
+}
+
+// updateSubscriptions applies a client subscription/ack message: it (a)
+// records every (service, resource type) tuple the client currently wants,
+// (b) drops ones it no longer does, and (c) records the version the client
+// just acked for each tuple, which suppresses the next push if nothing
+// changed since.
+func (s *aggregatedDiscoverSession) updateSubscriptions(in *api.AggregatedDiscoverRequest) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	next := make(map[resourceKey]bool, len(in.GetSubscriptions()))
+	for _, sub := range in.GetSubscriptions() {
+		for _, resType := range sub.GetTypes() {
+			key := resourceKey{
+				Namespace:    sub.GetService().GetNamespace().GetValue(),
+				Service:      sub.GetService().GetName().GetValue(),
+				ResourceType: resType,
+			}
+			next[key] = true
+			if version := sub.GetVersionInfo()[int32(resType)]; version != "" {
+				s.ackedVersion[key] = version
+			}
+			if !s.subscribed[key] {
+				s.schedule(key)
+			}
+		}
+	}
+	for key := range s.subscribed {
+		if !next[key] {
+			delete(s.ackedVersion, key)
+		}
+	}
+	s.subscribed = next
+}
+
+// schedule wakes the send loop for key without blocking. Keys are not
+// de-duplicated: the same key can sit in the queue more than once, and each
+// copy is resolved against the current version and filtered by shouldPush.
+func (s *aggregatedDiscoverSession) schedule(key resourceKey) {
+	select {
+	case s.pendingPushes <- key:
+	default:
+		// channel full: the key is dropped here; the poll loop schedules
+		// every subscribed key again on its next tick
+	}
+}
+
+// subscribedKeys returns a snapshot of every (service, resource type) tuple
+// currently subscribed, for the poll loop to re-check.
+func (s *aggregatedDiscoverSession) subscribedKeys() []resourceKey {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]resourceKey, 0, len(s.subscribed))
+	for key := range s.subscribed {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// shouldPush reports whether version is new information for key, i.e. the
+// client has not already acked it.
+func (s *aggregatedDiscoverSession) shouldPush(key resourceKey, version string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ackedVersion[key] != version
+}
+
+func (s *aggregatedDiscoverSession) stop(err error) {
+	s.closeOnce.Do(func() {
+		s.done <- err
+		close(s.done)
+	})
+}
+
+// AggregatedDiscover implements a single xDS-style stream carrying every
+// resource type a client needs for a service (instances, routing, rate
+// limit, circuit breaker) instead of one request per type. The client sends
+// a subscription listing (service, resource types) tuples plus the version
+// it last acked for each; the server pushes a resource again only once its
+// version changes. The naming cache in this tree has no invalidation-event
+// hook to drive pushes from, so every subscription is re-checked on
+// aggregatedDiscoverPollInterval instead; shouldPush still means the client
+// never receives a resource it has already acked the current version of.
+// WatchConfigFiles makes the same trade-off for the same reason.
+//
+// Scope note: the original request asked for event-driven delivery, i.e. a
+// cache invalidation callback waking the stream the instant a resource
+// changes. That callback hook does not exist on the naming cache in this
+// tree (cacheProvider has no subscribe-to-change API to wire into), and
+// adding one is a cache-layer change out of scope for this handler. What
+// ships here is a tick-based poll-and-diff approximation, not the
+// event-driven design the request described; the gap is bounded to
+// aggregatedDiscoverPollInterval of added latency per push, not left
+// unaddressed, but it should not be mistaken for the real thing.
+//
+// AggregatedDiscover, api.PolarisGRPC_AggregatedDiscoverServer and
+// api.AggregatedDiscoverRequest are generated from .proto sources that are
+// not part of this snapshot (no common/api/v1 package, no protoc toolchain,
+// no go.mod); this handler is written against the real upstream shape but
+// cannot be compiled in isolation in this tree.
+func (g *GRPCServer) AggregatedDiscover(stream api.PolarisGRPC_AggregatedDiscoverServer) error {
+	ctx := ConvertContext(stream.Context())
+	clientIP, _ := ctx.Value(utils.StringContext("client-ip")).(string)
+	clientAddress, _ := ctx.Value(utils.StringContext("client-address")).(string)
+	method, _ := grpc.MethodFromServerStream(stream)
+
+	session := newAggregatedDiscoverSession()
+
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF {
+					session.stop(nil)
+				} else {
+					session.stop(err)
+				}
+				return
+			}
+			session.updateSubscriptions(in)
+		}
+	}()
+
+	// 定期重新检查本连接订阅的每个 (service, resourceType)，版本有变化才会真正推送
+	poll := time.NewTicker(aggregatedDiscoverPollInterval)
+	defer poll.Stop()
+	go func() {
+		for {
+			select {
+			case <-poll.C:
+				for _, key := range session.subscribedKeys() {
+					session.schedule(key)
+				}
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case key := <-session.pendingPushes:
+			if code := g.enterRateLimit(clientIP, method); code != api.ExecuteSuccess {
+				continue
+			}
+			resp := g.resolveAggregatedResource(ctx, key)
+			version := resp.GetService().GetRevision().GetValue()
+			if !session.shouldPush(key, version) {
+				continue
+			}
+			log.Info("push aggregated discover resource",
+				zap.String("client-address", clientAddress),
+				zap.String("namespace", key.Namespace),
+				zap.String("service", key.Service),
+				zap.String("type", api.DiscoverRequest_DiscoverRequestType_name[int32(key.ResourceType)]))
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case err := <-session.done:
+			return err
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// resolveAggregatedResource fetches the current cached value for key using
+// the same per-type lookups the plain Discover RPC uses.
+func (g *GRPCServer) resolveAggregatedResource(ctx context.Context, key resourceKey) *api.DiscoverResponse {
+	service := &api.Service{
+		Namespace: utils.NewStringValue(key.Namespace),
+		Name:      utils.NewStringValue(key.Service),
+	}
+	switch key.ResourceType {
+	case api.DiscoverRequest_INSTANCE:
+		return g.namingServer.ServiceInstancesCache(ctx, service)
+	case api.DiscoverRequest_ROUTING:
+		return g.namingServer.GetRoutingConfigWithCache(ctx, service)
+	case api.DiscoverRequest_RATE_LIMIT:
+		return g.namingServer.GetRateLimitWithCache(ctx, service)
+	case api.DiscoverRequest_CIRCUIT_BREAKER:
+		return g.namingServer.GetCircuitBreakerWithCache(ctx, service)
+	default:
+		return api.NewDiscoverRoutingResponse(api.InvalidDiscoverResource, service)
+	}
+}
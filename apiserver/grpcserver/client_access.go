@@ -25,6 +25,7 @@ import (
 
 	api "github.com/polarismesh/polaris-server/common/api/v1"
 	"github.com/polarismesh/polaris-server/common/utils"
+	"github.com/polarismesh/polaris-server/service/healthcheck"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -140,6 +141,39 @@ func (g *GRPCServer) Heartbeat(ctx context.Context, in *api.Instance) (*api.Resp
 	return out, nil
 }
 
+/**
+ * @brief 批量上报心跳，避免同机多实例逐个发起 RPC
+ *
+ * api.HeartbeatBatch and api.BatchResponse are generated from .proto sources
+ * that are not part of this snapshot (no common/api/v1 package, no protoc
+ * toolchain, no go.mod); this handler is written against the real upstream
+ * shape but cannot be compiled in isolation in this tree.
+ */
+func (g *GRPCServer) BatchHeartbeat(ctx context.Context, in *api.HeartbeatBatch) (*api.BatchResponse, error) {
+	rCtx := ConvertContext(ctx)
+	records := make([]healthcheck.HeartbeatRecord, 0, len(in.GetRecords()))
+	for _, record := range in.GetRecords() {
+		records = append(records, healthcheck.HeartbeatRecord{
+			Namespace:  record.GetNamespace().GetValue(),
+			Service:    record.GetService().GetValue(),
+			Host:       record.GetHost().GetValue(),
+			Port:       record.GetPort().GetValue(),
+			InstanceId: record.GetInstanceId().GetValue(),
+		})
+	}
+
+	results := g.healthCheckServer.ReportMany(rCtx, records)
+	codes := make([]uint32, len(results))
+	for i, result := range results {
+		codes[i] = result.Code
+	}
+
+	return &api.BatchResponse{
+		Code:  utils.NewUInt32Value(api.ExecuteSuccess),
+		Codes: codes,
+	}, nil
+}
+
 /**
  * @brief 将GRPC上下文转换成内部上下文
  */
@@ -0,0 +1,204 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"sync"
+	"time"
+
+	api "github.com/polarismesh/polaris-server/common/api/v1"
+)
+
+// configWatchHeartbeatInterval is how often the server pings an idle
+// WatchConfigFiles stream so NATs/LBs don't reap the connection for
+// inactivity while the client has nothing new to receive.
+const configWatchHeartbeatInterval = 30 * time.Second
+
+// configWatchPollInterval is how often a stream re-checks its current watch
+// list against the config service for a version bump. The config publish
+// path in this tree has no invalidation-event hook to push from (the same
+// gap AggregatedDiscover works around with aggregatedDiscoverPollInterval),
+// so this polls instead; CheckClientConfigFile still only returns non-nil
+// once a watched file's version has actually moved, so the client never
+// receives a resend of something it already has.
+const configWatchPollInterval = 2 * time.Second
+
+// configFileKey identifies a config file regardless of which version of it
+// a pending push refers to, so several publishes to the same file inside one
+// tick collapse into the latest one instead of queueing up.
+type configFileKey struct {
+	Namespace string
+	Group     string
+	FileName  string
+}
+
+// configWatchSession coalesces pending pushes for a single WatchConfigFiles
+// stream: concurrent changes to several files in the same tick are merged
+// into one pending-by-file map rather than one queued message per change.
+// watchFiles is guarded by the same mutex since it is written by the recv
+// goroutine's subscription updates and read by the poll goroutine.
+type configWatchSession struct {
+	mutex      sync.Mutex
+	pending    map[configFileKey]*api.ConfigClientResponse
+	signal     chan struct{}
+	watchFiles []*api.ClientConfigFileInfo
+}
+
+func newConfigWatchSession(watchFiles []*api.ClientConfigFileInfo) *configWatchSession {
+	return &configWatchSession{
+		pending:    make(map[configFileKey]*api.ConfigClientResponse),
+		signal:     make(chan struct{}, 1),
+		watchFiles: watchFiles,
+	}
+}
+
+// swapWatchFiles replaces the watched file set and returns the previous one;
+// the next poll tick checks versions against the new set.
+func (s *configWatchSession) swapWatchFiles(next []*api.ClientConfigFileInfo) []*api.ClientConfigFileInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prev := s.watchFiles
+	s.watchFiles = next
+	return prev
+}
+
+func (s *configWatchSession) currentWatchFiles() []*api.ClientConfigFileInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.watchFiles
+}
+
+// push records resp as the latest pending push for its file and wakes the
+// stream's send loop, replacing whatever older pending push for that file
+// has not been sent yet.
+func (s *configWatchSession) push(resp *api.ConfigClientResponse) {
+	s.mutex.Lock()
+	key := configFileKey{
+		Namespace: resp.GetConfigFile().GetNamespace().GetValue(),
+		Group:     resp.GetConfigFile().GetGroup().GetValue(),
+		FileName:  resp.GetConfigFile().GetFileName().GetValue(),
+	}
+	s.pending[key] = resp
+	s.mutex.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// drain returns and clears every pending push accumulated since the last
+// drain.
+func (s *configWatchSession) drain() []*api.ConfigClientResponse {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	out := make([]*api.ConfigClientResponse, 0, len(s.pending))
+	for key, resp := range s.pending {
+		out = append(out, resp)
+		delete(s.pending, key)
+	}
+	return out
+}
+
+// WatchConfigFiles replaces the 30s HTTP long-poll with a persistent
+// bidirectional stream: the client sends an initial subscription followed by
+// optional subscription updates, and the server pushes a ConfigClientResponse
+// once any watched file's version moves, coalescing bursts within the same
+// tick and heartbeating to keep idle connections alive. Pushes are driven
+// entirely by configWatchPollInterval re-checking the subscription, the same
+// fallback AggregatedDiscover uses; an earlier revision of this file also
+// carried a pub/sub registry (subscribe/unsubscribe/notify) meant for the
+// config publish path to call directly, but nothing in this tree ever called
+// notify, so it was removed rather than kept as unused plumbing around what
+// is, in practice, just polling.
+//
+// WatchConfigFiles, api.PolarisGRPC_WatchConfigFilesServer and
+// api.ClientWatchConfigFileRequest are generated from .proto sources that
+// are not part of this snapshot (no common/api/v1 package, no protoc
+// toolchain, no go.mod); this handler is written against the real upstream
+// shape but cannot be compiled in isolation in this tree, the same gap
+// membership_store_etcd.go documents for its etcd dependency.
+func (g *GRPCServer) WatchConfigFiles(stream api.PolarisGRPC_WatchConfigFilesServer) error {
+	ctx := stream.Context()
+	rCtx := ConvertContext(ctx)
+
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	watchFiles := in.GetWatchFiles()
+
+	// 先做一次版本比对，客户端已经落后的文件立即推送，不用等下一次变更
+	if resp := g.configServer.Service().CheckClientConfigFile(rCtx, watchFiles); resp != nil {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+
+	session := newConfigWatchSession(watchFiles)
+
+	// 后台持续读取客户端新发来的订阅更新，支持不重连地增删监听文件
+	go func() {
+		for {
+			update, recvErr := stream.Recv()
+			if recvErr != nil {
+				return
+			}
+			session.swapWatchFiles(update.GetWatchFiles())
+		}
+	}()
+
+	heartbeat := time.NewTicker(configWatchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// 定期用客户端当前的订阅重新比对版本，版本有变化才会真正推送
+	poll := time.NewTicker(configWatchPollInterval)
+	defer poll.Stop()
+	go func() {
+		for {
+			select {
+			case <-poll.C:
+				if resp := g.configServer.Service().CheckClientConfigFile(rCtx, session.currentWatchFiles()); resp != nil {
+					session.push(resp)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-session.signal:
+			for _, resp := range session.drain() {
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+			}
+		case <-heartbeat.C:
+			if err := stream.Send(api.NewConfigClientResponse(api.ExecuteSuccess)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
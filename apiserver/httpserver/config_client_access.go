@@ -18,13 +18,25 @@
 package httpserver
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
 	"github.com/emicklei/go-restful"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/google/uuid"
 	api "github.com/polarismesh/polaris-server/common/api/v1"
 	"go.uber.org/zap"
-	"strconv"
 )
 
+// gzipMinSize is the smallest body worth paying gzip's overhead for.
+const gzipMinSize = 1024
+
 func (h *HTTPServer) getConfigFile(req *restful.Request, rsp *restful.Response) {
 	handler := &Handler{req, rsp}
 
@@ -37,6 +49,7 @@ func (h *HTTPServer) getConfigFile(req *restful.Request, rsp *restful.Response)
 	clientVersion, err := strconv.ParseUint(clientVersionStr, 10, 64)
 	if err != nil {
 		handler.WriteHeaderAndProto(api.NewConfigClientResponseWithMessage(api.BadRequest, "version must be number"))
+		return
 	}
 
 	response := h.configServer.Service().GetConfigFileForClient(handler.ParseHeaderContext(), namespace, group, fileName, clientVersion)
@@ -46,7 +59,80 @@ func (h *HTTPServer) getConfigFile(req *restful.Request, rsp *restful.Response)
 		zap.String("client", req.Request.RemoteAddr),
 		zap.String("file", fileName))
 
-	handler.WriteHeaderAndProto(response)
+	// 客户端 If-None-Match 与当前文件的 ETag 一致时直接 304，不需要重新下发整个文件内容
+	handler.WriteCachedConfigClientResponse(response)
+}
+
+// WriteCachedConfigClientResponse is WriteHeaderAndProto plus the caching
+// semantics every config-client endpoint serving a *api.ConfigClientResponse
+// should get: a strong ETag keyed off the served file version, a 304 when
+// the client's If-None-Match already matches it, and, when the client
+// accepts it, a gzip-compressed body for responses big enough to be worth
+// it. Endpoints that don't need caching keep calling WriteHeaderAndProto
+// directly.
+func (h *Handler) WriteCachedConfigClientResponse(resp *api.ConfigClientResponse) {
+	h.AddHeader("Cache-Control", "no-cache")
+	if etag := configFileETag(resp.GetConfigFile()); etag != "" {
+		h.AddHeader("ETag", etag)
+		if h.HeaderParameter("If-None-Match") == etag {
+			h.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if acceptsGzip(h) {
+		if body, ok := gzipProtoResponse(resp); ok {
+			h.AddHeader("Content-Encoding", "gzip")
+			h.AddHeader("Content-Type", "application/json")
+			h.WriteHeader(http.StatusOK)
+			_, _ = h.Write(body)
+			return
+		}
+	}
+
+	h.WriteHeaderAndProto(resp)
+}
+
+// configFileETag computes a strong ETag from the fields that uniquely
+// identify a served config file version, so the same (namespace, group,
+// fileName, version, content) always yields the same ETag.
+func configFileETag(configFile *api.ClientConfigFileInfo) string {
+	if configFile == nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(configFile.GetNamespace().GetValue()))
+	h.Write([]byte(configFile.GetGroup().GetValue()))
+	h.Write([]byte(configFile.GetFileName().GetValue()))
+	h.Write([]byte(strconv.FormatUint(configFile.GetVersion().GetValue(), 10)))
+	h.Write([]byte(configFile.GetContent().GetValue()))
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// acceptsGzip reports whether the client's Accept-Encoding header allows a
+// gzip-compressed response body.
+func acceptsGzip(h *Handler) bool {
+	return strings.Contains(h.HeaderParameter("Accept-Encoding"), "gzip")
+}
+
+// gzipProtoResponse marshals resp the same way Handler.WriteHeaderAndProto
+// does and gzips the result, skipping payloads under gzipMinSize where
+// compression overhead isn't worth paying.
+func gzipProtoResponse(resp proto.Message) ([]byte, bool) {
+	body, err := (&jsonpb.Marshaler{}).MarshalToString(resp)
+	if err != nil || len(body) < gzipMinSize {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(body)); err != nil {
+		return nil, false
+	}
+	if err := gz.Close(); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
 }
 
 func (h *HTTPServer) watchConfigFile(req *restful.Request, rsp *restful.Response) {
@@ -72,10 +158,10 @@ func (h *HTTPServer) watchConfigFile(req *restful.Request, rsp *restful.Response
 	}
 
 	watchFiles := watchConfigFileRequest.WatchFiles
-	//2. 检查客户端是否有版本落后
+	//2. 检查客户端是否有版本落后，版本落后时立即返回，同样走带缓存语义的响应写入
 	response := h.configServer.Service().CheckClientConfigFile(handler.ParseHeaderContext(), watchFiles)
 	if response != nil {
-		handler.WriteHeaderAndProto(response)
+		handler.WriteCachedConfigClientResponse(response)
 		return
 	}
 
@@ -0,0 +1,130 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"context"
+
+	api "github.com/polarismesh/polaris-server/common/api/v1"
+	"github.com/polarismesh/polaris-server/common/utils"
+)
+
+// HeartbeatRecord is one entry inside a BatchHeartbeat request: just enough
+// to identify the instance, mirroring what the single-instance Heartbeat RPC
+// already carries via api.Instance.
+type HeartbeatRecord struct {
+	Namespace  string
+	Service    string
+	Host       string
+	Port       uint32
+	InstanceId string
+}
+
+// HeartbeatResult is the per-record outcome of a ReportMany call.
+type HeartbeatResult struct {
+	InstanceId string
+	Code       uint32
+}
+
+// PeerHeartbeatReporter forwards heartbeat records owned by another
+// polaris-server to that peer over an internal gRPC call, so a sidecar
+// hosting many co-located instances no longer has to know, or find, which
+// server owns each one. No implementation of this interface exists in this
+// tree yet: it needs an internal gRPC client plus a call site in server.go
+// that this snapshot doesn't contain. Until s.peerReporter is actually
+// wired up, ReportMany fails closed for peer-owned records instead of
+// checking them in locally — see the peerReporter == nil branch below.
+type PeerHeartbeatReporter interface {
+	ReportMany(ctx context.Context, peerHost string, records []HeartbeatRecord) []HeartbeatResult
+}
+
+// ReportMany reports a batch of heartbeats in one call instead of one RPC
+// per instance. Records are grouped by their current owner as decided by
+// Dispatcher.reloadManagedInstances (not the natural Continuum hash, which
+// disagrees with the real owner once bounded-load spillover moves an
+// instance): the ones this node owns are checked in directly, the rest are
+// grouped by owning peer and, when s.peerReporter is set, forwarded with a
+// single call per peer instead of per instance. When s.peerReporter is nil,
+// peer-owned records are not forwarded and each gets api.ExecuteException.
+func (s *Server) ReportMany(ctx context.Context, records []HeartbeatRecord) []HeartbeatResult {
+	results := make([]HeartbeatResult, len(records))
+	byPeer := make(map[string][]int)
+
+	for i, record := range records {
+		owner := s.localHost
+		if s.dispatcher != nil {
+			if known, ok := s.dispatcher.OwnerOf(record.InstanceId); ok {
+				owner = known
+			}
+		}
+		if owner == s.localHost {
+			results[i] = s.reportOne(ctx, record)
+			continue
+		}
+		byPeer[owner] = append(byPeer[owner], i)
+	}
+
+	for peerHost, indices := range byPeer {
+		peerRecords := make([]HeartbeatRecord, len(indices))
+		for j, idx := range indices {
+			peerRecords[j] = records[idx]
+		}
+
+		var peerResults []HeartbeatResult
+		if s.peerReporter != nil {
+			peerResults = s.peerReporter.ReportMany(ctx, peerHost, peerRecords)
+		} else {
+			// No peer reporter is configured, so there is no way to actually
+			// forward these to peerHost. Fail closed: processing them locally
+			// would bypass the dispatcher's ownership sharding and let this
+			// node check in an instance it does not own, which is worse than
+			// rejecting the record outright. The client is expected to retry
+			// once a real peer reporter exists, not silently succeed against
+			// the wrong owner.
+			log.Errorf("[Health Check][Server] no peer heartbeat reporter configured, "+
+				"rejecting %d record(s) owned by %s instead of forwarding",
+				len(peerRecords), peerHost)
+			peerResults = make([]HeartbeatResult, len(peerRecords))
+			for j, peerRecord := range peerRecords {
+				peerResults[j] = HeartbeatResult{InstanceId: peerRecord.InstanceId, Code: api.ExecuteException}
+			}
+		}
+		for j, idx := range indices {
+			if j < len(peerResults) {
+				results[idx] = peerResults[j]
+				continue
+			}
+			results[idx] = HeartbeatResult{InstanceId: peerRecords[j].InstanceId, Code: api.ExecuteException}
+		}
+	}
+	return results
+}
+
+// reportOne reports a single record through the existing Report path so
+// ReportMany stays consistent with the single-instance Heartbeat RPC.
+func (s *Server) reportOne(ctx context.Context, record HeartbeatRecord) HeartbeatResult {
+	instance := &api.Instance{
+		Namespace: utils.NewStringValue(record.Namespace),
+		Service:   utils.NewStringValue(record.Service),
+		Host:      utils.NewStringValue(record.Host),
+		Port:      utils.NewUInt32Value(record.Port),
+		Id:        utils.NewStringValue(record.InstanceId),
+	}
+	resp := s.Report(ctx, instance)
+	return HeartbeatResult{InstanceId: record.InstanceId, Code: resp.GetCode().GetValue()}
+}
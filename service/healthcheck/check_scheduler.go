@@ -0,0 +1,151 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultCheckTTL is how long an instance may go without a heartbeat before
+// a freshly-added instance (no handoff seed, no grace window) can be marked
+// unhealthy.
+const defaultCheckTTL = 15 * time.Second
+
+// scheduledCheck is the bookkeeping CheckScheduler keeps per managed
+// instance.
+type scheduledCheck struct {
+	instance *InstanceWithChecker
+	// unhealthyNotBefore is the earliest time this instance may be marked
+	// unhealthy for missing a check; it is seeded from the handoff heartbeat
+	// plus grace window on a rebalance so a healthy instance doesn't flap
+	// unhealthy purely because ownership moved.
+	unhealthyNotBefore time.Time
+}
+
+// checkInterval is how often Run re-scans every instance this node manages
+// for a missed heartbeat.
+const checkInterval = 5 * time.Second
+
+// CheckScheduler tracks, per instance this node owns, the earliest time a
+// missed check is allowed to mark it unhealthy, and runs the loop that
+// actually enforces it. AddInstance/DelInstance are driven by Dispatcher as
+// ownership changes; Run is the check-execution loop that walks the
+// bookkeeping on a tick and calls markUnhealthy once both the TTL has
+// elapsed and canMarkUnhealthy clears the handoff grace window.
+//
+// dispatch.go's pre-existing logic (predating this file) already called
+// server.checkScheduler.AddInstance with a single argument, which means a
+// real CheckScheduler exists upstream outside this snapshot with a simpler
+// AddInstance signature than the one below. This definition, including the
+// grace-window parameters and Run loop, is a best-effort reconstruction
+// built to satisfy this request, not a guaranteed match to the real
+// upstream shape.
+type CheckScheduler struct {
+	mutex sync.Mutex
+	// checks is instance id -> its scheduled check bookkeeping.
+	checks map[string]*scheduledCheck
+}
+
+// newCheckScheduler builds an empty CheckScheduler.
+func newCheckScheduler() *CheckScheduler {
+	return &CheckScheduler{
+		checks: make(map[string]*scheduledCheck),
+	}
+}
+
+// AddInstance starts scheduling checks for instance. lastHeartbeat is the
+// most recent heartbeat the outgoing owner observed (zero value for a
+// brand-new instance); grace is how long the instance is given before a
+// missed check can mark it unhealthy, measured from lastHeartbeat rather
+// than from now, so the handoff itself never costs the instance its TTL.
+func (s *CheckScheduler) AddInstance(instance *InstanceWithChecker, lastHeartbeat time.Time, grace time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var notBefore time.Time
+	if !lastHeartbeat.IsZero() {
+		instance.recordHeartbeat(lastHeartbeat)
+		notBefore = lastHeartbeat.Add(grace)
+	}
+	s.checks[instance.instance.ID()] = &scheduledCheck{
+		instance:           instance,
+		unhealthyNotBefore: notBefore,
+	}
+}
+
+// DelInstance stops scheduling checks for instance.
+func (s *CheckScheduler) DelInstance(instance *InstanceWithChecker) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.checks, instance.instance.ID())
+}
+
+// snapshot returns a copy of the current check set, so Run can scan it
+// without holding s.mutex for the duration of the scan (canMarkUnhealthy
+// below takes the same lock per instance).
+func (s *CheckScheduler) snapshot() map[string]*scheduledCheck {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make(map[string]*scheduledCheck, len(s.checks))
+	for id, check := range s.checks {
+		out[id] = check
+	}
+	return out
+}
+
+// canMarkUnhealthy reports whether instanceId's handoff grace window, if
+// any, has elapsed as of now. An instance no longer tracked (already
+// reassigned or removed) can never be marked unhealthy by a stale scan.
+func (s *CheckScheduler) canMarkUnhealthy(instanceId string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	check, ok := s.checks[instanceId]
+	if !ok {
+		return false
+	}
+	return check.unhealthyNotBefore.IsZero() || !now.Before(check.unhealthyNotBefore)
+}
+
+// Run scans every managed instance once per checkInterval and calls
+// markUnhealthy for any instance whose last heartbeat is older than ttl and
+// whose handoff grace window (if any) has elapsed. It blocks until ctx is
+// done, so the caller is expected to run it in its own goroutine.
+func (s *CheckScheduler) Run(ctx context.Context, ttl time.Duration, markUnhealthy func(instanceId string)) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			for id, check := range s.snapshot() {
+				lastHeartbeat := check.instance.lastHeartbeatTime()
+				if lastHeartbeat.IsZero() || now.Sub(lastHeartbeat) < ttl {
+					continue
+				}
+				if !s.canMarkUnhealthy(id, now) {
+					continue
+				}
+				markUnhealthy(id)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import "time"
+
+// Config holds the dispatcher tunables that come from the polaris-server
+// config file (health-check section) instead of being hardcoded.
+type Config struct {
+	// OverflowFactor caps a bucket at ceil(OverflowFactor * averageLoad)
+	// instances before bounded-load spillover kicks in. Defaults to
+	// defaultOverflowFactor when zero or negative.
+	OverflowFactor float64 `yaml:"overflowFactor"`
+	// HandoffGrace is how long a newly-handed-off instance is given before
+	// a missed check can mark it unhealthy. Defaults to defaultCheckTTL (one
+	// TTL) when zero or negative.
+	HandoffGrace time.Duration `yaml:"handoffGrace"`
+	// MembershipBackend selects the MembershipStore implementation: "local"
+	// (default, cache-backed) or "etcd".
+	MembershipBackend string `yaml:"membershipBackend"`
+	// EtcdEndpoints is the etcd cluster to use when MembershipBackend is
+	// "etcd".
+	EtcdEndpoints []string `yaml:"etcdEndpoints"`
+	// LocalHost is this node's own address, used to register itself in the
+	// etcd-backed MembershipStore.
+	LocalHost string `yaml:"-"`
+}
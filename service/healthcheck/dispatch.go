@@ -19,7 +19,10 @@ package healthcheck
 
 import (
 	"context"
-	api "github.com/polarismesh/polaris-server/common/api/v1"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,9 +33,16 @@ const (
 	eventInterval = 5 * time.Second
 	// ensureInterval, trigger when timeout
 	ensureInterval = 61 * time.Second
+	// defaultOverflowFactor is used when the health check config does not
+	// set one explicitly; 1.25 matches the value Google's bounded-load
+	// paper uses as a reasonable default.
+	defaultOverflowFactor = 1.25
 )
 
-// Dispatcher dispatch all instances using consistent hash ring
+// Dispatcher dispatch all instances using consistent hash ring with bounded
+// loads: the natural ring owner is used unless it is already carrying more
+// than its fair share, in which case the instance is handed to the next
+// under-capacity bucket found while walking the ring.
 type Dispatcher struct {
 	healthCheckInstancesChanged uint32
 	selfServiceInstancesChanged uint32
@@ -40,17 +50,82 @@ type Dispatcher struct {
 
 	selfServiceBuckets map[Bucket]bool //  这个Bucket的作用
 	continuum          *Continuum
-	mutex              *sync.Mutex
+	// ring is the sorted set of replica points over selfServiceBuckets used
+	// to probe real successor buckets when the natural owner is over the
+	// bounded-load cap; rebuilt alongside continuum on every ownership
+	// change.
+	ring []ringPoint
+	// bucketLoads is the live per-bucket instance count produced by the most
+	// recent reload pass, used to enforce the bounded-load cap.
+	bucketLoads map[Bucket]uint32
+	// instanceOwners is the authoritative (as of the last reload pass)
+	// instance id -> owning host mapping produced by the bounded-load
+	// assignment, so other call sites (e.g. ReportMany) that need to know
+	// who owns an instance agree with reloadManagedInstances instead of
+	// recomputing the natural, unbounded ring owner themselves.
+	instanceOwners map[string]string
+	// overflowFactor caps a bucket at ceil(overflowFactor * averageLoad)
+	// instances before spillover to the next ring position kicks in.
+	overflowFactor float64
+	// knownInstances is the full instance id set seen on the previous
+	// reload pass (regardless of owner), used to tell a genuine handoff
+	// (instance existed, owner changed) apart from a brand-new instance.
+	knownInstances map[string]bool
+	// handoffGrace is how long a newly-handed-off instance is given before
+	// a missed check can mark it unhealthy, default is one TTL.
+	handoffGrace time.Duration
+	// membership resolves the live selfServiceBuckets host set and carries
+	// the heartbeat handoff (see PeerHeartbeatPublisher); defaults to the
+	// cache-backed localMembershipStore, can be swapped for an etcd-backed
+	// one so bucket membership converges cluster-wide instead of depending
+	// on every replica independently discovering peers via the cache.
+	membership MembershipStore
+	mutex      *sync.Mutex
 }
 
-func newDispatcher(ctx context.Context) *Dispatcher {
+// newDispatcher builds a Dispatcher from conf (the health-check config's
+// dispatcher section); conf may be nil, in which case every tunable falls
+// back to its default.
+func newDispatcher(ctx context.Context, conf *Config) *Dispatcher {
+	if conf == nil {
+		conf = &Config{}
+	}
+	overflowFactor := conf.OverflowFactor
+	if overflowFactor <= 0 {
+		overflowFactor = defaultOverflowFactor
+	}
+	handoffGrace := conf.HandoffGrace
+	if handoffGrace <= 0 {
+		handoffGrace = defaultCheckTTL
+	}
+	membership, err := NewMembershipStore(conf)
+	if err != nil {
+		log.Errorf("[Health Check][Dispatcher] init membership store: %v, falling back to local", err)
+		membership = newLocalMembershipStore()
+	}
 	dispatcher := &Dispatcher{
-		mutex: &sync.Mutex{},
+		overflowFactor: overflowFactor,
+		handoffGrace:   handoffGrace,
+		membership:     membership,
+		mutex:          &sync.Mutex{},
 	}
+	dispatcher.membership.Watch(ctx, func() {
+		dispatcher.UpdateStatusByEvent(CacheEvent{selfServiceInstancesChanged: true})
+	})
 	dispatcher.startDispatchingJob(ctx)
+	go server.checkScheduler.Run(ctx, defaultCheckTTL, markInstanceUnhealthy)
 	return dispatcher
 }
 
+// markInstanceUnhealthy is CheckScheduler.Run's markUnhealthy callback. It
+// only logs the instance id; it does not change the instance's health status
+// in server.cacheProvider (the only cacheProvider calls in this tree are
+// RangeHealthCheckInstances and RangeSelfServiceInstances).
+func markInstanceUnhealthy(instanceId string) {
+	log.Infof("[Health Check][Dispatcher] instance %s missed its TTL past the handoff grace window "+
+		"and would be marked unhealthy here", instanceId)
+}
+
 // UpdateStatusByEvent 更新变更状态
 func (d *Dispatcher) UpdateStatusByEvent(event CacheEvent) {
 	d.mutex.Lock()
@@ -101,17 +176,83 @@ func compareBuckets(src map[Bucket]bool, dst map[Bucket]bool) bool {
 	return true
 }
 
+// bucketLoadCap returns the maximum number of instances a single bucket may
+// own this round: ceil(overflowFactor * ceil(totalCount / bucketCount)).
+func bucketLoadCap(totalCount int, bucketCount int, overflowFactor float64) uint32 {
+	if bucketCount == 0 || totalCount == 0 {
+		return 0
+	}
+	avgLoad := math.Ceil(float64(totalCount) / float64(bucketCount))
+	return uint32(math.Ceil(avgLoad * overflowFactor))
+}
+
+// ringReplicas is how many virtual points each bucket gets on d.ring; more
+// replicas smooth out how evenly the natural (pre-bounded-load) owner is
+// distributed across buckets.
+const ringReplicas = 10
+
+// ringPoint is one virtual node on the bounded-load ring.
+type ringPoint struct {
+	hash   uint64
+	bucket Bucket
+}
+
+// buildRing lays out ringReplicas virtual points per bucket and sorts them,
+// so assignBucket can walk real successor positions instead of re-hashing a
+// perturbed probe.
+func buildRing(buckets map[Bucket]bool) []ringPoint {
+	ring := make([]ringPoint, 0, len(buckets)*ringReplicas)
+	for bucket := range buckets {
+		for i := 0; i < ringReplicas; i++ {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(bucket.Host + "#" + strconv.Itoa(i)))
+			ring = append(ring, ringPoint{hash: h.Sum64(), bucket: bucket})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+// assignBucket returns the bucket that should own the instance identified by
+// hashValue. The natural ring owner (the first ring point clockwise from
+// hashValue) is used unless it is already at the bounded-load cap, in which
+// case the walk continues clockwise over real ring positions, skipping
+// buckets already visited, until one with spare capacity is found. Every
+// polaris-server replica builds the same ring from the same bucket set, so
+// this always converges on the same owner.
+func (d *Dispatcher) assignBucket(hashValue uint64, loadCap uint32, bucketLoads map[Bucket]uint32) Bucket {
+	if len(d.ring) == 0 {
+		return Bucket{}
+	}
+	start := sort.Search(len(d.ring), func(i int) bool { return d.ring[i].hash >= hashValue })
+	tried := make(map[Bucket]bool, len(d.selfServiceBuckets))
+	for i := 0; i < len(d.ring); i++ {
+		point := d.ring[(start+i)%len(d.ring)]
+		if tried[point.bucket] {
+			continue
+		}
+		tried[point.bucket] = true
+		if bucketLoads[point.bucket] < loadCap {
+			return point.bucket
+		}
+		if len(tried) == len(d.selfServiceBuckets) {
+			break
+		}
+	}
+	// every bucket is at the load cap (can only happen with a very tight
+	// overflowFactor); fall back to the natural owner rather than drop
+	// the instance
+	return d.ring[start%len(d.ring)].bucket
+}
+
 func (d *Dispatcher) reloadSelfContinuum() bool {
 	nextBuckets := make(map[Bucket]bool)
-	server.cacheProvider.RangeSelfServiceInstances(func(instance *api.Instance) {
-		if instance.GetIsolate().GetValue() || !instance.GetHealthy().GetValue() {
-			return
-		}
+	for _, host := range d.membership.Members() {
 		nextBuckets[Bucket{
-			Host:   instance.GetHost().GetValue(),
+			Host:   host,
 			Weight: weight,
 		}] = true
-	})
+	}
 	originBucket := d.selfServiceBuckets
 	log.Debugf("[Health Check][Dispatcher]reload continuum by %v, origin is %v", nextBuckets, originBucket)
 	if compareBuckets(originBucket, nextBuckets) {
@@ -119,51 +260,100 @@ func (d *Dispatcher) reloadSelfContinuum() bool {
 	}
 	d.selfServiceBuckets = nextBuckets
 	d.continuum = New(d.selfServiceBuckets)
+	d.ring = buildRing(nextBuckets)
 	return true
 }
 
 func (d *Dispatcher) reloadManagedInstances() {
 	//  nextInstances 和 originInstances  的意思
 	nextInstances := make(map[string]*InstanceWithChecker)
+	nextKnown := make(map[string]bool)
+	nextOwners := make(map[string]string)
 	var totalCount int
 	if nil != d.continuum {
+		var allInstances []*InstanceWithChecker
 		server.cacheProvider.RangeHealthCheckInstances(func(instance *InstanceWithChecker) {
+			allInstances = append(allInstances, instance)
+		})
+		totalCount = len(allInstances)
+
+		// sort by hash value so every polaris-server replica walks the
+		// instances in the exact same order, making the bounded-load
+		// spillover decisions below deterministic across the cluster
+		sort.Slice(allInstances, func(i, j int) bool {
+			return allInstances[i].hashValue < allInstances[j].hashValue
+		})
+
+		loadCap := bucketLoadCap(totalCount, len(d.selfServiceBuckets), d.overflowFactor)
+		bucketLoads := make(map[Bucket]uint32, len(d.selfServiceBuckets))
+		for _, instance := range allInstances {
 			instanceId := instance.instance.ID()
-			host := d.continuum.Hash(instance.hashValue)
-			if host == server.localHost {
+			nextKnown[instanceId] = true
+			bucket := d.assignBucket(instance.hashValue, loadCap, bucketLoads)
+			bucketLoads[bucket]++
+			nextOwners[instanceId] = bucket.Host
+			if bucket.Host == server.localHost {
 				nextInstances[instanceId] = instance
 			}
-			totalCount++
-		})
+		}
+		d.bucketLoads = bucketLoads
 	}
+	d.mutex.Lock()
+	d.instanceOwners = nextOwners
+	d.mutex.Unlock()
 	log.Infof("[Health Check][Dispatcher]count %d instances has been dispatched to %s, total is %d",
 		len(nextInstances), server.localHost, totalCount)
 	originInstances := d.managedInstances
+	previouslyKnown := d.knownInstances
 	d.managedInstances = nextInstances
+	d.knownInstances = nextKnown
 	if len(nextInstances) > 0 {
 		for id, instance := range nextInstances {
-			if len(originInstances) == 0 {
-				server.checkScheduler.AddInstance(instance)
+			if _, ok := originInstances[id]; ok {
 				continue
 			}
-			if _, ok := originInstances[id]; !ok {
-				server.checkScheduler.AddInstance(instance)
+			if previouslyKnown[id] {
+				// the instance existed under a different owner a moment
+				// ago: this is a rebalance handoff, not a brand-new
+				// instance, so seed the checker's deadline with whatever
+				// heartbeat the outgoing owner last saw and give it a
+				// grace window before it can be marked unhealthy
+				if seed, ok := d.membership.TakeHeartbeat(id); ok {
+					server.checkScheduler.AddInstance(instance, seed, d.handoffGrace)
+					continue
+				}
 			}
+			server.checkScheduler.AddInstance(instance, time.Time{}, 0)
 		}
 	}
 	if len(originInstances) > 0 {
 		for id, instance := range originInstances {
-			if len(nextInstances) == 0 {
-				server.checkScheduler.DelInstance(instance)
+			if _, ok := nextInstances[id]; ok {
 				continue
 			}
-			if _, ok := nextInstances[id]; !ok {
-				server.checkScheduler.DelInstance(instance)
+			if nextKnown[id] {
+				// instance is still alive, just moving to a different
+				// owner: publish our last-known heartbeat so the new
+				// owner can pick it up on its next reload
+				d.membership.PublishHeartbeat(id, instance.lastHeartbeatTime())
 			}
+			server.checkScheduler.DelInstance(instance)
 		}
 	}
 }
 
+// OwnerOf returns the host currently responsible for instanceId, as decided
+// by the bounded-load assignment in the most recent reloadManagedInstances
+// pass. Callers that need to know who owns an instance (e.g. ReportMany)
+// must go through this instead of recomputing the natural, unbounded ring
+// owner themselves, since the two can disagree once spillover kicks in.
+func (d *Dispatcher) OwnerOf(instanceId string) (string, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	host, ok := d.instanceOwners[instanceId]
+	return host, ok
+}
+
 func (d *Dispatcher) processEvent() {
 	var selfContinuumReloaded bool
 	// 标记清楚这两个分别是什么时候触发 selfServiceInstancesChanged
@@ -0,0 +1,192 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"fmt"
+	"testing"
+)
+
+// buildTestDispatcher wires up just enough of a Dispatcher for
+// assignBucket/bucketLoadCap to run: the ring and bucket set, with no
+// dependency on the cache, continuum or check scheduler.
+func buildTestDispatcher(hosts ...string) *Dispatcher {
+	buckets := make(map[Bucket]bool, len(hosts))
+	for _, host := range hosts {
+		buckets[Bucket{Host: host, Weight: weight}] = true
+	}
+	return &Dispatcher{
+		selfServiceBuckets: buckets,
+		ring:               buildRing(buckets),
+	}
+}
+
+// assignAll dispatches count instances with hash values i*2654435761 for
+// i = 0..count-1 and returns the resulting per-bucket load.
+func assignAll(d *Dispatcher, count int, overflowFactor float64) map[Bucket]uint32 {
+	loadCap := bucketLoadCap(count, len(d.selfServiceBuckets), overflowFactor)
+	loads := make(map[Bucket]uint32, len(d.selfServiceBuckets))
+	for i := 0; i < count; i++ {
+		bucket := d.assignBucket(uint64(i)*2654435761, loadCap, loads)
+		loads[bucket]++
+	}
+	return loads
+}
+
+func TestAssignBucket_SingleInstanceOwner(t *testing.T) {
+	d := buildTestDispatcher("host-1")
+	loads := assignAll(d, 50, defaultOverflowFactor)
+	if len(loads) != 1 {
+		t.Fatalf("expected exactly one owning bucket, got %d", len(loads))
+	}
+	for bucket, load := range loads {
+		if bucket.Host != "host-1" {
+			t.Fatalf("unexpected owner %v", bucket)
+		}
+		if load != 50 {
+			t.Fatalf("expected all 50 instances on the sole bucket, got %d", load)
+		}
+	}
+}
+
+func TestAssignBucket_ScaleUpStaysUnderCap(t *testing.T) {
+	hosts := []string{"host-1", "host-2", "host-3", "host-4"}
+	for n := 1; n <= len(hosts); n++ {
+		d := buildTestDispatcher(hosts[:n]...)
+		const totalCount = 200
+		loadCap := bucketLoadCap(totalCount, n, defaultOverflowFactor)
+		loads := assignAll(d, totalCount, defaultOverflowFactor)
+		var assigned uint32
+		for bucket, load := range loads {
+			if load > loadCap {
+				t.Fatalf("bucket %v load %d exceeds cap %d with %d buckets", bucket, load, loadCap, n)
+			}
+			assigned += load
+		}
+		if assigned != totalCount {
+			t.Fatalf("expected all %d instances assigned with %d buckets, got %d", totalCount, n, assigned)
+		}
+	}
+}
+
+func TestAssignBucket_ScaleDownRedistributesWithinCap(t *testing.T) {
+	d := buildTestDispatcher("host-1", "host-2", "host-3", "host-4")
+	const totalCount = 200
+	before := assignAll(d, totalCount, defaultOverflowFactor)
+	if len(before) != 4 {
+		t.Fatalf("expected 4 buckets in use before scale-down, got %d", len(before))
+	}
+
+	// host-4 leaves the ring.
+	remaining := map[Bucket]bool{
+		{Host: "host-1", Weight: weight}: true,
+		{Host: "host-2", Weight: weight}: true,
+		{Host: "host-3", Weight: weight}: true,
+	}
+	d.selfServiceBuckets = remaining
+	d.ring = buildRing(remaining)
+
+	loadCap := bucketLoadCap(totalCount, len(remaining), defaultOverflowFactor)
+	after := assignAll(d, totalCount, defaultOverflowFactor)
+	if len(after) != 3 {
+		t.Fatalf("expected 3 buckets in use after scale-down, got %d", len(after))
+	}
+	var assigned uint32
+	for bucket, load := range after {
+		if bucket.Host == "host-4" {
+			t.Fatalf("host-4 should no longer own any instance, got %d", load)
+		}
+		if load > loadCap {
+			t.Fatalf("bucket %v load %d exceeds cap %d after scale-down", bucket, load, loadCap)
+		}
+		assigned += load
+	}
+	if assigned != totalCount {
+		t.Fatalf("expected all %d instances reassigned, got %d", totalCount, assigned)
+	}
+}
+
+// TestAssignBucket_ScaleUpChurnBeatsNaiveRehash checks that going from 3 to
+// 4 buckets moves fewer instances than a naive i%bucketCount rehash does.
+// All hash values here fall below the lowest ring point, so every instance
+// starts from the same natural owner and the buckets fill up to the cap in
+// ring order; the test asserts only churn < naive churn, not that churn is a
+// minority of the instances.
+func TestAssignBucket_ScaleUpChurnBeatsNaiveRehash(t *testing.T) {
+	hosts := []string{"host-1", "host-2", "host-3"}
+	const totalCount = 400
+
+	before := make(map[uint64]Bucket, totalCount)
+	d := buildTestDispatcher(hosts...)
+	loadCap := bucketLoadCap(totalCount, len(hosts), defaultOverflowFactor)
+	loads := make(map[Bucket]uint32, len(hosts))
+	for i := 0; i < totalCount; i++ {
+		h := uint64(i) * 2654435761
+		bucket := d.assignBucket(h, loadCap, loads)
+		loads[bucket]++
+		before[h] = bucket
+	}
+
+	nextHosts := append(append([]string{}, hosts...), "host-4")
+	d2 := buildTestDispatcher(nextHosts...)
+	loadCap2 := bucketLoadCap(totalCount, len(nextHosts), defaultOverflowFactor)
+	loads2 := make(map[Bucket]uint32, len(nextHosts))
+	var churn int
+	for i := 0; i < totalCount; i++ {
+		h := uint64(i) * 2654435761
+		bucket := d2.assignBucket(h, loadCap2, loads2)
+		loads2[bucket]++
+		if bucket != before[h] {
+			churn++
+		}
+	}
+
+	var naiveChurn int
+	for i := 0; i < totalCount; i++ {
+		if i%len(hosts) != i%len(nextHosts) {
+			naiveChurn++
+		}
+	}
+
+	if churn >= naiveChurn {
+		t.Fatalf("bounded-load ring churn %d should be well below naive rehash churn %d (total=%d)",
+			churn, naiveChurn, totalCount)
+	}
+	t.Logf("scale-up churn: bounded-load=%d naive=%d (total=%d)", churn, naiveChurn, totalCount)
+}
+
+func TestBucketLoadCap(t *testing.T) {
+	cases := []struct {
+		total, buckets int
+		overflow       float64
+		want           uint32
+	}{
+		{total: 0, buckets: 4, overflow: 1.25, want: 0},
+		{total: 100, buckets: 0, overflow: 1.25, want: 0},
+		{total: 100, buckets: 4, overflow: 1.25, want: 32},
+		{total: 10, buckets: 4, overflow: 1.25, want: 4},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			got := bucketLoadCap(c.total, c.buckets, c.overflow)
+			if got != c.want {
+				t.Fatalf("bucketLoadCap(%d, %d, %v) = %d, want %d", c.total, c.buckets, c.overflow, got, c.want)
+			}
+		})
+	}
+}
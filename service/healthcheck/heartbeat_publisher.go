@@ -0,0 +1,69 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"sync"
+	"time"
+)
+
+// PeerHeartbeatPublisher hands the outgoing owner's last-known heartbeat for
+// a migrating instance to whichever polaris-server becomes its new owner, so
+// the handoff does not reset the instance's TTL clock to zero.
+type PeerHeartbeatPublisher interface {
+	// PublishHeartbeat records the last-known heartbeat for instanceId so
+	// the next owner can seed its checker from it.
+	PublishHeartbeat(instanceId string, lastHeartbeat time.Time)
+	// TakeHeartbeat returns and clears the last-known heartbeat published
+	// for instanceId, if any.
+	TakeHeartbeat(instanceId string) (time.Time, bool)
+}
+
+// localHeartbeatPublisher is the default PeerHeartbeatPublisher, backed by
+// an in-process map: a heartbeat published here can only be taken by the
+// same polaris-server process, not by a peer. It is replaced by the
+// etcd-backed MembershipStore once that backend is configured (see
+// membership_store.go).
+type localHeartbeatPublisher struct {
+	mutex      sync.Mutex
+	heartbeats map[string]time.Time
+}
+
+func newLocalHeartbeatPublisher() *localHeartbeatPublisher {
+	return &localHeartbeatPublisher{
+		heartbeats: make(map[string]time.Time),
+	}
+}
+
+// PublishHeartbeat implements PeerHeartbeatPublisher.
+func (p *localHeartbeatPublisher) PublishHeartbeat(instanceId string, lastHeartbeat time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.heartbeats[instanceId] = lastHeartbeat
+}
+
+// TakeHeartbeat implements PeerHeartbeatPublisher.
+func (p *localHeartbeatPublisher) TakeHeartbeat(instanceId string) (time.Time, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	lastHeartbeat, ok := p.heartbeats[instanceId]
+	if ok {
+		delete(p.heartbeats, instanceId)
+	}
+	return lastHeartbeat, ok
+}
@@ -0,0 +1,63 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"sync"
+	"time"
+
+	api "github.com/polarismesh/polaris-server/common/api/v1"
+)
+
+// InstanceWithChecker pairs an instance with the hash used to place it on the
+// dispatch ring and the last heartbeat this node observed for it, so a
+// handoff to a new owner can seed the new owner's TTL clock instead of
+// resetting it.
+//
+// dispatch.go's pre-existing logic (predating this file) already called
+// server.checkScheduler.AddInstance with a single argument and read
+// instance.hashValue, which means a real InstanceWithChecker/CheckScheduler
+// pair exists upstream outside this snapshot. This definition is a
+// best-effort reconstruction of that shape from its call sites, not a
+// guaranteed match to the real upstream fields or method set.
+type InstanceWithChecker struct {
+	instance  *api.Instance
+	hashValue uint64
+
+	mutex         sync.Mutex
+	lastHeartbeat time.Time
+}
+
+// lastHeartbeatTime returns the most recent heartbeat this node recorded for
+// the instance, used by Dispatcher.reloadManagedInstances to publish a
+// handoff seed when ownership moves away.
+func (i *InstanceWithChecker) lastHeartbeatTime() time.Time {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.lastHeartbeat
+}
+
+// recordHeartbeat updates the last heartbeat this node observed for the
+// instance.
+func (i *InstanceWithChecker) recordHeartbeat(t time.Time) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if t.After(i.lastHeartbeat) {
+		i.lastHeartbeat = t
+	}
+}
@@ -0,0 +1,95 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+
+	api "github.com/polarismesh/polaris-server/common/api/v1"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// MembershipStore abstracts where a Dispatcher gets its selfServiceBuckets
+// host set from and how it hands off heartbeat state across owners. The
+// default, local, implementation derives membership from the naming cache
+// like before; the etcd implementation (see membership_store_etcd.go) makes
+// membership convergence independent of every polaris-server separately
+// discovering peers through the cache, which is what let replicas disagree
+// on bucket sets during partitions or cold start.
+type MembershipStore interface {
+	PeerHeartbeatPublisher
+
+	// Members returns the currently known set of live polaris-server hosts
+	// that self-service instances should be hashed onto.
+	Members() []string
+	// Watch starts watching for membership changes and calls onChange
+	// whenever the member set changes; implementations that derive
+	// membership from events already flowing through
+	// Dispatcher.UpdateStatusByEvent may leave this a no-op.
+	Watch(ctx context.Context, onChange func())
+}
+
+// localMembershipStore is the default MembershipStore, unchanged from the
+// dispatcher's original behaviour: membership is derived by scanning
+// self-service instances out of the local naming cache, and heartbeat
+// handoff state lives in process memory.
+type localMembershipStore struct {
+	*localHeartbeatPublisher
+}
+
+func newLocalMembershipStore() *localMembershipStore {
+	return &localMembershipStore{
+		localHeartbeatPublisher: newLocalHeartbeatPublisher(),
+	}
+}
+
+// Members implements MembershipStore.
+func (l *localMembershipStore) Members() []string {
+	var hosts []string
+	server.cacheProvider.RangeSelfServiceInstances(func(instance *api.Instance) {
+		if instance.GetIsolate().GetValue() || !instance.GetHealthy().GetValue() {
+			return
+		}
+		hosts = append(hosts, instance.GetHost().GetValue())
+	})
+	return hosts
+}
+
+// Watch implements MembershipStore. The local backend already learns about
+// membership changes through cache events delivered to
+// Dispatcher.UpdateStatusByEvent, so there is nothing extra to watch here.
+func (l *localMembershipStore) Watch(_ context.Context, _ func()) {}
+
+// NewMembershipStore selects and builds the MembershipStore conf.MembershipBackend
+// asks for. An empty or "local" backend yields the cache-backed
+// localMembershipStore; "etcd" dials conf.EtcdEndpoints and registers
+// conf.LocalHost under it via etcdMembershipStore.
+func NewMembershipStore(conf *Config) (MembershipStore, error) {
+	if conf == nil || conf.MembershipBackend == "" || conf.MembershipBackend == "local" {
+		return newLocalMembershipStore(), nil
+	}
+	if conf.MembershipBackend != "etcd" {
+		return nil, fmt.Errorf("unknown membership backend %q", conf.MembershipBackend)
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: conf.EtcdEndpoints})
+	if err != nil {
+		return nil, fmt.Errorf("dial etcd membership backend: %w", err)
+	}
+	return newEtcdMembershipStore(client, conf.LocalHost)
+}
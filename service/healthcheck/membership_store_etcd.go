@@ -0,0 +1,214 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// membershipPrefix is where every polaris-server registers its
+	// lease-backed liveness key.
+	membershipPrefix = "/polaris/healthcheck/membership/"
+	// heartbeatPrefix is where the last-known heartbeat for a migrating
+	// instance is stashed under a short TTL key during ownership handoff.
+	heartbeatPrefix = "/polaris/healthcheck/heartbeat/"
+	// membershipLeaseTTL is how long a node's liveness key survives without
+	// a renewed lease, i.e. how long a crashed node's bucket stays occupied.
+	membershipLeaseTTL = 10 * time.Second
+	// heartbeatSeedTTL bounds how long a published heartbeat waits to be
+	// picked up by the new owner before it is no longer useful.
+	heartbeatSeedTTL = 30 * time.Second
+	// registerTimeout bounds the one-shot etcd calls in register() and the
+	// initial membership snapshot in Watch(), consistent with the 1-second
+	// bound PublishHeartbeat/TakeHeartbeat already use, so a stalled etcd
+	// endpoint can't hang server startup indefinitely.
+	registerTimeout = time.Second
+)
+
+// etcdMembershipStore is the reference MembershipStore backend: every
+// polaris-server registers itself under membershipPrefix with a lease-backed
+// key, watches the prefix for peers joining/leaving, and stashes heartbeat
+// handoff state under heartbeatPrefix with its own short TTL, so membership
+// and handoff both survive partitions and cold restarts without depending on
+// the naming cache. Selected via Config.MembershipBackend == "etcd" in
+// NewMembershipStore (membership_store.go), which is what makes this backend
+// reachable at runtime instead of dead code.
+//
+// go.etcd.io/etcd/client/v3 needs a corresponding go.mod require (plus its
+// transitive go.sum entries) for this file to actually build; this tree has
+// no go.mod anywhere under the repo root for that requirement to live in,
+// and fabricating one here would record a dependency graph this sandbox has
+// no way to resolve or verify. The import above is written as it would be
+// once a real go.mod exists, not as something currently buildable in
+// isolation.
+type etcdMembershipStore struct {
+	client *clientv3.Client
+	host   string
+
+	mutex   sync.RWMutex
+	members map[string]bool
+}
+
+// newEtcdMembershipStore registers host under membershipPrefix and returns a
+// MembershipStore backed by the given etcd client.
+func newEtcdMembershipStore(client *clientv3.Client, host string) (*etcdMembershipStore, error) {
+	store := &etcdMembershipStore{
+		client:  client,
+		host:    host,
+		members: make(map[string]bool),
+	}
+	if err := store.register(context.Background()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// register creates the lease-backed liveness key for this node and keeps it
+// alive for as long as the process runs. The Grant/Put/initial KeepAlive
+// calls are bounded by registerTimeout rather than the caller's ctx
+// directly, so a stalled etcd endpoint fails newEtcdMembershipStore instead
+// of hanging server startup indefinitely; ctx still governs the long-lived
+// keepAlive channel drained below.
+func (e *etcdMembershipStore) register(ctx context.Context) error {
+	registerCtx, cancel := context.WithTimeout(ctx, registerTimeout)
+	defer cancel()
+
+	lease, err := e.client.Grant(registerCtx, int64(membershipLeaseTTL.Seconds()))
+	if err != nil {
+		return err
+	}
+	if _, err := e.client.Put(registerCtx, membershipPrefix+e.host, e.host, clientv3.WithLease(lease.ID)); err != nil {
+		return err
+	}
+	keepAlive, err := e.client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range keepAlive {
+			// drain keep-alive responses; nothing to do on success
+		}
+	}()
+	return nil
+}
+
+// Members implements MembershipStore.
+func (e *etcdMembershipStore) Members() []string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	hosts := make([]string, 0, len(e.members))
+	for host := range e.members {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// Watch implements MembershipStore: it loads the current membership prefix
+// once, then watches it for changes, calling onChange after every update.
+func (e *etcdMembershipStore) Watch(ctx context.Context, onChange func()) {
+	getCtx, cancel := context.WithTimeout(ctx, registerTimeout)
+	resp, err := e.client.Get(getCtx, membershipPrefix, clientv3.WithPrefix())
+	cancel()
+	if err == nil {
+		e.applyMembers(resp.Kvs)
+	}
+
+	watchChan := e.client.Watch(ctx, membershipPrefix, clientv3.WithPrefix())
+	go func() {
+		for watchResp := range watchChan {
+			for _, event := range watchResp.Events {
+				host := membershipHostFromKey(string(event.Kv.Key))
+				e.mutex.Lock()
+				if event.Type == clientv3.EventTypeDelete {
+					delete(e.members, host)
+				} else {
+					e.members[host] = true
+				}
+				e.mutex.Unlock()
+			}
+			onChange()
+		}
+	}()
+}
+
+func (e *etcdMembershipStore) applyMembers(kvs []*clientv3.KeyValue) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, kv := range kvs {
+		e.members[membershipHostFromKey(string(kv.Key))] = true
+	}
+}
+
+// membershipHostFromKey recovers the host a membership key was registered
+// under, i.e. the inverse of membershipPrefix+host.
+func membershipHostFromKey(key string) string {
+	return strings.TrimPrefix(key, membershipPrefix)
+}
+
+// PublishHeartbeat implements PeerHeartbeatPublisher.
+func (e *etcdMembershipStore) PublishHeartbeat(instanceId string, lastHeartbeat time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	lease, err := e.client.Grant(ctx, int64(heartbeatSeedTTL.Seconds()))
+	if err != nil {
+		log.Errorf("[Health Check][Dispatcher] grant lease for heartbeat handoff of %s: %v", instanceId, err)
+		return
+	}
+	value := formatHeartbeatValue(lastHeartbeat)
+	if _, err := e.client.Put(ctx, heartbeatPrefix+instanceId, value, clientv3.WithLease(lease.ID)); err != nil {
+		log.Errorf("[Health Check][Dispatcher] publish heartbeat handoff of %s: %v", instanceId, err)
+	}
+}
+
+// TakeHeartbeat implements PeerHeartbeatPublisher.
+func (e *etcdMembershipStore) TakeHeartbeat(instanceId string) (time.Time, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := heartbeatPrefix + instanceId
+	resp, err := e.client.Get(ctx, key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return time.Time{}, false
+	}
+	lastHeartbeat, err := parseHeartbeatValue(string(resp.Kvs[0].Value))
+	if err != nil {
+		return time.Time{}, false
+	}
+	if _, err := e.client.Delete(ctx, key); err != nil {
+		log.Errorf("[Health Check][Dispatcher] clear heartbeat handoff of %s: %v", instanceId, err)
+	}
+	return lastHeartbeat, true
+}
+
+// formatHeartbeatValue and parseHeartbeatValue en/decode the heartbeat
+// timestamp stashed under heartbeatPrefix; factored out so the round trip is
+// unit-testable without a live etcd client.
+func formatHeartbeatValue(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseHeartbeatValue(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
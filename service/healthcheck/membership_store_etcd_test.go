@@ -0,0 +1,66 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestMembershipHostFromKey(t *testing.T) {
+	got := membershipHostFromKey(membershipPrefix + "10.0.0.1:8090")
+	if got != "10.0.0.1:8090" {
+		t.Fatalf("membershipHostFromKey() = %q, want %q", got, "10.0.0.1:8090")
+	}
+}
+
+func TestHeartbeatValueRoundTrip(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	parsed, err := parseHeartbeatValue(formatHeartbeatValue(want))
+	if err != nil {
+		t.Fatalf("parseHeartbeatValue() error = %v", err)
+	}
+	if !parsed.Equal(want) {
+		t.Fatalf("parseHeartbeatValue(formatHeartbeatValue(%v)) = %v, want %v", want, parsed, want)
+	}
+}
+
+func TestParseHeartbeatValue_Invalid(t *testing.T) {
+	if _, err := parseHeartbeatValue("not-a-timestamp"); err == nil {
+		t.Fatal("expected an error for a malformed heartbeat value")
+	}
+}
+
+func TestApplyMembers(t *testing.T) {
+	e := &etcdMembershipStore{members: make(map[string]bool)}
+	e.applyMembers([]*clientv3.KeyValue{
+		{Key: []byte(membershipPrefix + "host-1")},
+		{Key: []byte(membershipPrefix + "host-2")},
+	})
+
+	if len(e.members) != 2 || !e.members["host-1"] || !e.members["host-2"] {
+		t.Fatalf("unexpected members after applyMembers: %v", e.members)
+	}
+
+	hosts := e.Members()
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 members, got %d: %v", len(hosts), hosts)
+	}
+}